@@ -18,17 +18,21 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/sirupsen/logrus"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"github.com/kubernetes-csi/csi-lib-utils/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
 	cosispec "sigs.k8s.io/container-object-storage-interface-spec"
 
+	"github.com/huawei/cosi-driver/pkg/utils/connection"
 	"github.com/huawei/cosi-driver/pkg/utils/log"
 	"github.com/huawei/cosi-driver/pkg/utils/version"
 )
@@ -37,85 +41,277 @@ var (
 	probeTimeout  = flag.Duration("probe-timeout", time.Second, "Probe timeout in seconds.")
 	driverAddress = flag.String("driver-address", "/var/lib/cosi/cosi.sock",
 		"driver address for socket")
-	httpEndpoint   = flag.String("http-endpoint", "", "HTTP server endPoint for health probe check")
+	httpEndpoint = flag.String("http-endpoint", "", "HTTP server endPoint for health probe check")
+	metricsPath  = flag.String("metrics-path", "/metrics", "HTTP path under which to expose probe metrics")
+	pollTime     = flag.Duration("poll-time", 0, "Interval for periodically polling DriverGetInfo and "+
+		"publishing a cosi_liveness gauge. Zero disables the background poller.")
+	metricsEndpoint = flag.String("metrics-endpoint", "", "HTTP server endPoint for the background poller's "+
+		"liveness gauge, e.g. \":9808\". Required when --poll-time is set.")
 	logFile        = flag.String("log-file", "liveness-probe", "The log file name of the liveness probe.")
 	kubeConfigPath = flag.String("kube-config-path", "", "absolute path to the kubeConfig file")
+	driverName     = flag.String("driver-name", "", "Expected COSI driver name; when set, DriverGetInfo's "+
+		"response must report this name or the probe fails, catching a probe wired to the wrong socket.")
+	requireReady = flag.Bool("require-ready", false, "Additionally re-query DriverGetInfo and require a "+
+		"consistent, name-matching response before reporting healthy.")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second,
+		"Grace period to let in-flight probe requests finish before the HTTP server is forcibly closed.")
 )
 
 const (
 	containerName = "liveness-probe"
+
+	// livenessSubsystem is the Prometheus subsystem under which the background
+	// poller publishes its gauge, kept separate from the per-RPC CSI metrics.
+	livenessSubsystem = "cosi"
 )
 
 func main() {
+	log.AddFlags(flag.CommandLine)
 	flag.Parse()
 
 	err := log.InitLogging(*logFile)
 	if err != nil {
-		logrus.Errorf("init log failed, error is [%v]", err)
+		klog.Background().Error(err, "init log failed")
 		return
 	}
 
 	ctx, err := log.SetRequestInfo(context.Background())
 	if err != nil {
-		log.Errorf("set request info failed, error is [%v]", err)
+		klog.Background().Error(err, "set request info failed")
 		return
 	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	logger := klog.FromContext(ctx)
 
 	err = version.RegisterVersion(containerName, version.LivenessProbeVersion, *kubeConfigPath)
 	if err != nil {
-		log.AddContext(ctx).Errorf("init version file failed, error is [%v]", err)
+		logger.Error(err, "init version file failed")
 		return
 	}
 
+	// SIGKILL cannot be caught by a process, so it is intentionally left out
+	// of this set; only SIGINT/SIGTERM trigger the graceful shutdown path.
 	signalChan := make(chan os.Signal, 1)
 	defer close(signalChan)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGILL, syscall.SIGKILL, syscall.SIGTERM)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+
+	metricsManager := metrics.NewCSIMetricsManager("")
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", checkProbe)
+	mux.HandleFunc("/healthz", checkProbe(ctx, metricsManager))
+	mux.Handle(*metricsPath, promhttp.HandlerFor(metricsManager.GetRegistry(), promhttp.HandlerOpts{}))
+
+	if *pollTime > 0 {
+		if *metricsEndpoint == "" {
+			logger.Error(nil, "--metrics-endpoint must be set when --poll-time is non-zero")
+			return
+		}
+		go runLivenessPoller(ctx, *pollTime, *metricsEndpoint, metricsManager)
+	}
+
+	server := &http.Server{Addr: *httpEndpoint, Handler: mux}
 	go func(ch chan os.Signal) {
-		err = http.ListenAndServe(*httpEndpoint, mux)
-		if err != nil {
-			log.AddContext(ctx).Errorf("probe http server listen [%s] failed, error is [%v]", httpEndpoint, err)
-			ch <- syscall.SIGINT
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error(err, "probe http server listen failed", "http-endpoint", *httpEndpoint)
+			ch <- syscall.SIGTERM
 			return
 		}
 	}(signalChan)
-	log.AddContext(ctx).Infoln("start probe http server successfully!")
+	logger.Info("start probe http server successfully", "http-endpoint", *httpEndpoint)
 
 	stopSignal := <-signalChan
-	log.AddContext(ctx).Warningf("stop probe service successfully, stopSignal is [%v]", stopSignal)
+	logger.Info("received stop signal, shutting down probe service", "signal", stopSignal)
+
+	// Cancel the root context first so in-flight probe RPCs and the
+	// background poller abort promptly, then give the HTTP server a bounded
+	// grace period to drain in-flight requests.
+	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error(err, "graceful shutdown of probe http server failed")
+	}
+	logger.Info("stop probe service successfully", "signal", stopSignal)
 }
 
-func checkProbe(w http.ResponseWriter, req *http.Request) {
-	ctx, cancel := context.WithTimeout(req.Context(), *probeTimeout)
-	defer cancel()
+// runLivenessPoller periodically invokes DriverGetInfo against the COSI
+// driver and publishes the result as a cosi_liveness gauge on its own
+// registry and HTTP endpoint, independent of the kubelet-facing /healthz
+// probe. RPCs made by the poller are instrumented through metricsManager, so
+// per-RPC latency and error counters cover the poller the same way they
+// cover /healthz. It blocks until ctx is done.
+func runLivenessPoller(ctx context.Context, interval time.Duration, endpoint string,
+	metricsManager metrics.CSIMetricsManager) {
+	logger := klog.FromContext(ctx)
+
+	registry := prometheus.NewRegistry()
+	livenessGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: livenessSubsystem,
+		Name:      "liveness",
+		Help:      "Indicates whether the COSI driver is responding to DriverGetInfo, 1=ready, 0=failed.",
+	}, []string{"driver"})
+	registry.MustRegister(livenessGauge)
 
-	unixPrefix := "unix://"
-	providerAddress := unixPrefix + *driverAddress
-	dialOptions := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithBlock(),
-		grpc.WithIdleTimeout(time.Duration(0)),
+	mux := http.NewServeMux()
+	mux.Handle(*metricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	go func() {
+		if err := http.ListenAndServe(endpoint, mux); err != nil {
+			logger.Error(err, "liveness poller metrics server failed", "metrics-endpoint", endpoint)
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pollDriverInfo(ctx, livenessGauge, metricsManager)
+		}
 	}
-	conn, err := grpc.Dial(providerAddress, dialOptions...)
+}
+
+// pollDriverInfo performs a single DriverGetInfo call and records whether it
+// succeeded in the liveness gauge.
+func pollDriverInfo(ctx context.Context, livenessGauge *prometheus.GaugeVec, metricsManager metrics.CSIMetricsManager) {
+	logger := klog.FromContext(ctx)
+	driver := driverLabel()
+
+	pollCtx, cancel := context.WithTimeout(ctx, *probeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := connection.Connect(pollCtx, *driverAddress, metricsManager)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.AddContext(ctx).Errorf("failed to establish connection to cosi provider, error is [%v]", err)
+		logger.Error(err, "liveness poller failed to connect to cosi provider", "driver", driver)
+		livenessGauge.WithLabelValues(driver).Set(0)
 		return
 	}
 	defer conn.Close()
 
-	log.AddContext(ctx).Infoln("sending probe request to cosi driver")
 	client := cosispec.NewIdentityClient(conn)
-	_, err = client.DriverGetInfo(ctx, &cosispec.DriverGetInfoRequest{})
+	resp, err := client.DriverGetInfo(pollCtx, &cosispec.DriverGetInfoRequest{})
+	latencyMs := time.Since(start).Milliseconds()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.AddContext(ctx).Errorf("health check failed, error is [%v]", err)
+		logger.Error(err, "liveness poller health check failed",
+			"driver", driver, "latency-ms", latencyMs, "grpc-code", status.Code(err))
+		livenessGauge.WithLabelValues(driver).Set(0)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	log.AddContext(ctx).Infoln("health check successfully")
-	return
+	if err := validateDriverInfo(resp); err != nil {
+		logger.Error(err, "liveness poller driver identity validation failed", "driver", driver)
+		livenessGauge.WithLabelValues(driver).Set(0)
+		return
+	}
+
+	logger.V(1).Info("liveness poller health check succeeded", "driver", driver, "latency-ms", latencyMs)
+	livenessGauge.WithLabelValues(driver).Set(1)
+}
+
+// driverLabel returns the value used to label the cosi_liveness gauge: the
+// configured --driver-name when set, falling back to the socket address so
+// the gauge is still usable before that flag is adopted.
+func driverLabel() string {
+	if *driverName != "" {
+		return *driverName
+	}
+	return *driverAddress
+}
+
+// withDone returns a context derived from ctx that is additionally canceled
+// as soon as other is done, so a handler can honor both the per-request
+// deadline and the process-wide shutdown signal.
+func withDone(ctx, other context.Context) (context.Context, context.CancelFunc) {
+	derived, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-other.Done():
+			cancel()
+		case <-derived.Done():
+		}
+	}()
+	return derived, cancel
+}
+
+// validateDriverInfo checks resp against the expected --driver-name, when
+// configured. This catches the common multi-driver-node misconfiguration
+// where the probe sidecar's UDS is wired to a sibling driver's socket and
+// would otherwise still report healthy.
+func validateDriverInfo(resp *cosispec.DriverGetInfoResponse) error {
+	if *driverName != "" && resp.GetName() != *driverName {
+		return fmt.Errorf("driver name mismatch: expected [%s], got [%s]", *driverName, resp.GetName())
+	}
+	return nil
+}
+
+// checkDriverReady re-queries DriverGetInfo and validates its response. The
+// COSI identity service currently exposes only DriverGetInfo — there is no
+// dedicated readiness or capability RPC to call, unlike CSI's
+// GetPluginCapabilities — so readiness is approximated by requiring a
+// second, independent call to agree with the first.
+func checkDriverReady(ctx context.Context, client cosispec.IdentityClient,
+	first *cosispec.DriverGetInfoResponse) error {
+	resp, err := client.DriverGetInfo(ctx, &cosispec.DriverGetInfoRequest{})
+	if err != nil {
+		return fmt.Errorf("driver did not respond to readiness recheck: %v", err)
+	}
+	if resp.GetName() != first.GetName() {
+		return fmt.Errorf("driver name changed between calls: first [%s], then [%s]", first.GetName(), resp.GetName())
+	}
+	return validateDriverInfo(resp)
+}
+
+// checkProbe builds the /healthz handler. rootCtx is the process-lifetime
+// context, canceled on shutdown, so that an in-flight probe RPC aborts
+// promptly instead of holding up server.Shutdown for the full probe timeout.
+func checkProbe(rootCtx context.Context, metricsManager metrics.CSIMetricsManager) http.HandlerFunc {
+	baseLogger := klog.FromContext(rootCtx)
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), *probeTimeout)
+		defer cancel()
+		ctx, cancel = withDone(ctx, rootCtx)
+		defer cancel()
+		ctx = klog.NewContext(ctx, baseLogger)
+		logger := klog.FromContext(ctx).WithValues("driver", *driverAddress, "probe-timeout", probeTimeout.String())
+
+		conn, err := connection.Connect(ctx, *driverAddress, metricsManager)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			logger.Error(err, "failed to establish connection to cosi provider")
+			return
+		}
+		defer conn.Close()
+
+		logger.V(1).Info("sending probe request to cosi driver")
+		start := time.Now()
+		client := cosispec.NewIdentityClient(conn)
+		resp, err := client.DriverGetInfo(ctx, &cosispec.DriverGetInfoRequest{})
+		latencyMs := time.Since(start).Milliseconds()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			logger.Error(err, "health check failed", "latency-ms", latencyMs, "grpc-code", status.Code(err))
+			return
+		}
+
+		if err := validateDriverInfo(resp); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			logger.Error(err, "driver identity validation failed", "latency-ms", latencyMs)
+			return
+		}
+
+		if *requireReady {
+			if err := checkDriverReady(ctx, client, resp); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				logger.Error(err, "driver readiness check failed", "latency-ms", latencyMs)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		logger.Info("health check succeeded", "latency-ms", latencyMs)
+	}
 }
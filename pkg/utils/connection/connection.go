@@ -0,0 +1,101 @@
+/*
+ Copyright (c) Huawei Technologies Co., Ltd. 2024-2024. All rights reserved.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+      http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package connection provides a small helper for opening short-lived gRPC
+// connections to a COSI driver's unix domain socket, modeled after
+// csi-lib-utils' connection package.
+package connection
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kubernetes-csi/csi-lib-utils/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	unixPrefix = "unix://"
+
+	// backoffDuration is the wait interval between dial retries while the
+	// connection has not yet become ready.
+	backoffDuration = 100 * time.Millisecond
+)
+
+// Connect opens a new, non-blocking gRPC connection to address and blocks
+// until the connection becomes ready or ctx is done, whichever happens
+// first. The caller owns the returned connection and is responsible for
+// closing it.
+//
+// When metricsManager is non-nil, the connection is instrumented with a
+// unary interceptor that records per-RPC latency and error counters, so
+// every probe RPC made over this connection shows up in the COSI metrics
+// manager.
+func Connect(ctx context.Context, address string, metricsManager metrics.CSIMetricsManager) (*grpc.ClientConn, error) {
+	target := address
+	if !strings.HasPrefix(target, unixPrefix) {
+		target = unixPrefix + target
+	}
+
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithIdleTimeout(time.Duration(0)),
+	}
+	if metricsManager != nil {
+		dialOptions = append(dialOptions, grpc.WithUnaryInterceptor(metricsManager.GetInterceptor()))
+	}
+
+	conn, err := grpc.DialContext(ctx, target, dialOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial [%s]: %v", target, err)
+	}
+
+	if err := waitForReady(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// waitForReady blocks until conn reports READY or ctx expires, retrying the
+// connection attempt on every transient failure instead of blocking
+// indefinitely on a single dial attempt.
+func waitForReady(ctx context.Context, conn *grpc.ClientConn) error {
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		conn.Connect()
+
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("connection to [%s] did not become ready: %v", conn.Target(), ctx.Err())
+		}
+
+		if conn.GetState() == connectivity.Ready {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("connection to [%s] did not become ready: %v", conn.Target(), ctx.Err())
+		case <-time.After(backoffDuration):
+		}
+	}
+}
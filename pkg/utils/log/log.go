@@ -0,0 +1,76 @@
+/*
+ Copyright (c) Huawei Technologies Co., Ltd. 2024-2024. All rights reserved.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+      http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package log configures contextual, structured logging for the liveness
+// probe. It is a thin wrapper around klog/v2 and logr.Logger: call AddFlags
+// before flag.Parse, InitLogging once at startup, then attach request-scoped
+// fields with SetRequestInfo and retrieve the logger anywhere downstream
+// with klog.FromContext(ctx), so every log line carries key/value fields
+// instead of pre-formatted strings.
+package log
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"time"
+
+	logsapi "k8s.io/component-base/logs/api/v1"
+	_ "k8s.io/component-base/logs/json/register" // registers the "json" --logging-format with logsapi
+	"k8s.io/klog/v2"
+)
+
+// loggingConfig holds the --logging-format (and related) flags registered by
+// AddFlags and applied by InitLogging.
+var loggingConfig = logsapi.NewLoggingConfiguration()
+
+// AddFlags registers the shared klog flags plus --logging-format=text|json
+// on fs. It must be called before fs.Parse.
+func AddFlags(fs *flag.FlagSet) {
+	klog.InitFlags(fs)
+	logsapi.AddGoFlags(loggingConfig, fs)
+}
+
+// InitLogging validates and applies the configured logging format -
+// switching klog to a JSON logr backend via logsapi when requested - and,
+// when logFile is non-empty, mirrors output to that file instead of stderr.
+// It must be called once, after flag.Parse and before the first log line.
+func InitLogging(logFile string) error {
+	if logFile != "" {
+		if err := flag.CommandLine.Set("log_file", logFile); err != nil {
+			return fmt.Errorf("failed to set log file to [%s]: %v", logFile, err)
+		}
+		if err := flag.CommandLine.Set("logtostderr", "false"); err != nil {
+			return fmt.Errorf("failed to disable logtostderr: %v", err)
+		}
+	} else if err := flag.CommandLine.Set("alsologtostderr", "true"); err != nil {
+		return fmt.Errorf("failed to enable alsologtostderr: %v", err)
+	}
+
+	if err := logsapi.ValidateAndApply(loggingConfig, nil); err != nil {
+		return fmt.Errorf("failed to apply logging configuration: %v", err)
+	}
+
+	return nil
+}
+
+// SetRequestInfo derives a request-scoped logr.Logger tagged with a unique
+// request-id and attaches it to ctx, so every downstream klog.FromContext
+// call for this request carries the same identifier.
+func SetRequestInfo(ctx context.Context) (context.Context, error) {
+	requestID := fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Uint32())
+	logger := klog.Background().WithValues("request-id", requestID)
+	return klog.NewContext(ctx, logger), nil
+}
@@ -0,0 +1,32 @@
+/*
+ Copyright (c) Huawei Technologies Co., Ltd. 2024-2024. All rights reserved.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+      http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package log
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestInitLoggingJSONFormat(t *testing.T) {
+	AddFlags(flag.CommandLine)
+
+	if err := flag.CommandLine.Set("logging-format", "json"); err != nil {
+		t.Fatalf("failed to set --logging-format=json: %v", err)
+	}
+
+	if err := InitLogging(""); err != nil {
+		t.Fatalf("InitLogging with --logging-format=json returned an error: %v", err)
+	}
+}